@@ -0,0 +1,143 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+
+	"github.com/m3db/m3x/checked"
+)
+
+// errBloomFilterTruncated is returned when a bloom filter sidecar file's
+// contents are too short to contain a valid header and bit set.
+var errBloomFilterTruncated = errors.New("bloom filter file is truncated")
+
+// bloomFilter is a fixed-size Bloom filter sized up-front from an
+// expected number of inserts and a target false positive rate. It
+// answers "definitely not present" queries cheaply for IDs that were
+// never written to a fileset, without touching the summaries file.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloomFilter returns a bloomFilter sized to hold n elements at
+// (approximately) the given false positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n <= 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m == 0 {
+		m = 1
+	}
+	k := uint64(math.Round((float64(m) / float64(n)) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add inserts id into the filter.
+func (f *bloomFilter) Add(id []byte) {
+	h1, h2 := f.hash(id)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Test returns false if id is definitely not present, or true if it may
+// be present (subject to the configured false positive rate).
+func (f *bloomFilter) Test(id []byte) bool {
+	h1, h2 := f.hash(id)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash returns two independent hashes of id, combined via double hashing
+// (Kirsch-Mitzenmacher) to derive the k indices a standard Bloom filter
+// needs without running k independent hash functions.
+func (f *bloomFilter) hash(id []byte) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write(id)
+	sum1 := h1.Sum64()
+
+	h2 := fnv.New64()
+	h2.Write(id)
+	sum2 := h2.Sum64()
+
+	return sum1, sum2
+}
+
+// bytes serializes the filter to its on-disk representation: an 8-byte
+// bit count, an 8-byte k, followed by the packed bit words.
+func (f *bloomFilter) bytes() []byte {
+	buf := make([]byte, 16+8*len(f.bits))
+	binary.LittleEndian.PutUint64(buf[0:8], f.m)
+	binary.LittleEndian.PutUint64(buf[8:16], f.k)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(buf[16+8*i:24+8*i], word)
+	}
+	return buf
+}
+
+// newBloomFilterFromBytes deserializes a filter written by bytes(), e.g.
+// one read from a bloom filter sidecar file.
+func newBloomFilterFromBytes(data checked.Bytes) (*bloomFilter, error) {
+	buf := data.Get()
+	if len(buf) < 16 {
+		return nil, errBloomFilterTruncated
+	}
+
+	m := binary.LittleEndian.Uint64(buf[0:8])
+	k := binary.LittleEndian.Uint64(buf[8:16])
+
+	words := buf[16:]
+	if len(words)%8 != 0 || uint64(len(words)/8) < (m+63)/64 {
+		return nil, errBloomFilterTruncated
+	}
+
+	bits := make([]uint64, len(words)/8)
+	for i := range bits {
+		bits[i] = binary.LittleEndian.Uint64(words[8*i : 8*i+8])
+	}
+
+	return &bloomFilter{bits: bits, m: m, k: k}, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"fmt"
+
+	"github.com/m3db/m3db/digest"
+	"github.com/m3db/m3db/ts"
+
+	"github.com/m3db/m3x/checked"
+)
+
+// bloomFilteredIndexLookup wraps an indexLookup with the per-block Bloom
+// filter sidecar, so that IDs which were never written can be rejected
+// with a single in-memory bit-set test instead of walking the radixTree
+// (and, in the pre-radixTree world, the summaries binary search).
+type bloomFilteredIndexLookup struct {
+	lookup *indexLookup
+	filter *bloomFilter
+}
+
+// newBloomFilteredIndexLookup reads and validates the Bloom filter
+// sidecar for an already-built indexLookup, wrapping the two together.
+// The sidecar is read in full via fdWithDigest (buffered, digest-checked
+// I/O through the Filesystem abstraction) rather than mmap'd, since the
+// Filesystem this runs against need not be backed by a real file (e.g.
+// the in-memory or remote implementations used in tests); loading via
+// mmap would require an mmap-capable Filesystem variant, which does not
+// exist yet.
+func newBloomFilteredIndexLookup(
+	lookup *indexLookup,
+	bloomFile File,
+	fdWithDigest *digest.FdWithDigestReader,
+	expectedDigest uint32,
+) (*bloomFilteredIndexLookup, error) {
+	fdWithDigest.Reset(bloomFile)
+
+	buf, err := fdWithDigest.ReadAllAndValidate(expectedDigest)
+	if err != nil {
+		return nil, fmt.Errorf("err validating bloom filter file digest: %v", err)
+	}
+
+	filterBytes := checked.NewBytes(buf, nil)
+	filterBytes.IncRef()
+
+	filter, err := newBloomFilterFromBytes(filterBytes)
+	if err != nil {
+		return nil, fmt.Errorf("err reading bloom filter: %v", err)
+	}
+
+	return &bloomFilteredIndexLookup{lookup: lookup, filter: filter}, nil
+}
+
+// getNearestIndexFileOffset mirrors indexLookup.getNearestIndexFileOffset,
+// first consulting the Bloom filter: a negative there short-circuits with
+// ok=false before the radixTree is ever touched.
+func (l *bloomFilteredIndexLookup) getNearestIndexFileOffset(id ts.ID) (int64, bool, error) {
+	idBytes := id.Data().Get()
+
+	if !l.filter.Test(idBytes) {
+		return 0, false, nil
+	}
+
+	return l.lookup.getNearestIndexFileOffset(id)
+}
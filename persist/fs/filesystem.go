@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// File is the subset of *os.File that fileset readers/writers depend on.
+// It is satisfied by *os.File as well as any in-memory or remote-backed
+// implementation registered via Options.SetFilesystem.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+
+	// Name returns the name of the file as presented to Open/Create.
+	Name() string
+
+	// Stat returns the FileInfo describing the file.
+	Stat() (os.FileInfo, error)
+
+	// Sync commits the current contents of the file to stable storage.
+	Sync() error
+}
+
+// Filesystem abstracts the subset of disk I/O that the persist/fs package
+// needs in order to read and write fileset files. The default
+// implementation, returned by NewOSFilesystem, simply delegates to the
+// os and ioutil packages. Alternate implementations can back cold blocks
+// with object storage (S3, GCS, HDFS, ...), or inject faults for testing
+// durability of the index/summaries/data/checkpoint files, without any
+// of the call sites in this package changing.
+type Filesystem interface {
+	// Open opens the named file for reading.
+	Open(name string) (File, error)
+
+	// Create creates (or truncates) the named file for writing.
+	Create(name string) (File, error)
+
+	// Stat returns the FileInfo describing the named file.
+	Stat(name string) (os.FileInfo, error)
+
+	// MkdirAll creates a directory and any necessary parents, mirroring
+	// os.MkdirAll.
+	MkdirAll(path string, perm os.FileMode) error
+
+	// ReadDir reads the directory named by dirname and returns a list of
+	// sorted directory entries, mirroring ioutil.ReadDir.
+	ReadDir(dirname string) ([]os.FileInfo, error)
+
+	// Remove removes the named file or (empty) directory.
+	Remove(name string) error
+}
+
+// NewOSFilesystem returns a Filesystem that is backed by the local disk,
+// using the standard os and ioutil packages. It is the default Filesystem
+// used by Options when none is explicitly set.
+func NewOSFilesystem() Filesystem {
+	return osFilesystem{}
+}
+
+type osFilesystem struct{}
+
+func (osFilesystem) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFilesystem) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (osFilesystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+func (osFilesystem) Remove(name string) error {
+	return os.Remove(name)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/m3db/m3db/ts"
+)
+
+const (
+	indexFileSuffix       = "index"
+	summariesFileSuffix   = "summaries"
+	dataFileSuffix        = "data"
+	infoFileSuffix        = "info"
+	checkpointFileSuffix  = "checkpoint"
+	bloomFilterFileSuffix = "bloom"
+
+	timeFormat = "1536504365"
+)
+
+// ShardDirPath returns the path to the directory for a given shard within
+// a namespace.
+func ShardDirPath(prefix string, namespace ts.ID, shard uint32) string {
+	return filepath.Join(prefix, namespace.String(), strconv.Itoa(int(shard)))
+}
+
+// filesetPathFromTime returns the path to a fileset file of the given
+// suffix (index, summaries, data, info, checkpoint, ...) for the block
+// starting at t within shardDirPath.
+func filesetPathFromTime(shardDirPath string, t time.Time, suffix string) string {
+	name := fmt.Sprintf("fileset-%d-%s.db", t.UnixNano(), suffix)
+	return filepath.Join(shardDirPath, name)
+}
+
+// mkdirIfNotExist creates dirPath (and any parents) via the provided
+// Filesystem if it does not already exist.
+func mkdirIfNotExist(fs Filesystem, dirPath string) error {
+	if _, err := fs.Stat(dirPath); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return fs.MkdirAll(dirPath, os.FileMode(0755))
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// infoFileDigests records the checksums of the data, index, summaries and
+// Bloom filter files of a fileset, so that on open each can be
+// independently verified against the value recorded at write time --
+// including the Bloom filter sidecar, so filter corruption is caught
+// rather than silently producing bad negative lookups. The checkpoint
+// file has no digest here.
+type infoFileDigests struct {
+	dataDigest      uint32
+	indexDigest     uint32
+	summariesDigest uint32
+	bloomDigest     uint32
+}
+
+func (d infoFileDigests) bytes() []byte {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint32(buf[0:4], d.dataDigest)
+	binary.LittleEndian.PutUint32(buf[4:8], d.indexDigest)
+	binary.LittleEndian.PutUint32(buf[8:12], d.summariesDigest)
+	binary.LittleEndian.PutUint32(buf[12:16], d.bloomDigest)
+	return buf
+}
+
+func readInfoFileDigests(buf []byte) (infoFileDigests, error) {
+	if len(buf) < 16 {
+		return infoFileDigests{}, fmt.Errorf("info file is truncated: got %d bytes", len(buf))
+	}
+	return infoFileDigests{
+		dataDigest:      binary.LittleEndian.Uint32(buf[0:4]),
+		indexDigest:     binary.LittleEndian.Uint32(buf[4:8]),
+		summariesDigest: binary.LittleEndian.Uint32(buf[8:12]),
+		bloomDigest:     binary.LittleEndian.Uint32(buf[12:16]),
+	}, nil
+}
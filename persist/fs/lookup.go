@@ -0,0 +1,104 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"fmt"
+
+	"github.com/m3db/m3db/digest"
+	"github.com/m3db/m3db/persist/encoding"
+	"github.com/m3db/m3db/persist/encoding/msgpack"
+	"github.com/m3db/m3db/ts"
+)
+
+// fileSetIndexLookup is implemented by both indexLookup and its
+// bloomFilteredIndexLookup wrapper, so callers (and the indexLookupCache)
+// don't need to care whether a Bloom filter sidecar is in play.
+type fileSetIndexLookup interface {
+	getNearestIndexFileOffset(id ts.ID) (int64, bool, error)
+}
+
+// indexLookup resolves a series ID to the offset of its entry in the
+// index file, using the (sparse) summaries file as a way to avoid
+// scanning the whole index file linearly. It is backed by an immutable
+// radixTree: once built for an opened fileset it is never mutated again,
+// so concurrent getNearestIndexFileOffset calls need no locking, and a
+// new fileset flush can swap in a freshly built tree without blocking
+// readers still using the old one.
+type indexLookup struct {
+	tree *radixTree
+}
+
+// readIndexLookupFromSummariesFile reads every entry out of the
+// summaries file (verifying the digest as it goes) and returns an
+// indexLookup capable of answering getNearestIndexFileOffset for any ID.
+// Entries are expected in ID order (the order FileSetWriter requires
+// them to be written in), so they are streamed straight into the tree
+// without an intervening sort.
+func readIndexLookupFromSummariesFile(
+	summariesFile File,
+	fdWithDigest *digest.FdWithDigestReader,
+	expectedDigest uint32,
+	decoder *msgpack.Decoder,
+	numEntries int,
+) (*indexLookup, error) {
+	fdWithDigest.Reset(summariesFile)
+
+	buf, err := fdWithDigest.ReadAllAndValidate(expectedDigest)
+	if err != nil {
+		return nil, fmt.Errorf("err validating summaries file digest: %v", err)
+	}
+
+	decoder.Reset(encoding.NewDecoderStream(buf))
+
+	tree := newRadixTree()
+	for {
+		summary, err := decoder.DecodeIndexSummary()
+		if err != nil {
+			break
+		}
+		tree = tree.Insert(summary.ID, radixValue{indexOffset: summary.IndexEntryOffset})
+	}
+
+	return &indexLookup{tree: tree}, nil
+}
+
+// getNearestIndexFileOffset returns the nearest index file offset at or
+// before id's own entry, and whether any such entry exists at all. Only
+// options.IndexSummariesPercent() of IDs are ever inserted into the tree
+// (3% by default), so most real IDs have no exact match; the tree's
+// Floor lookup instead returns the closest preceding sampled entry, which
+// is exactly the "nearest lower key" the summaries file was built to
+// answer -- callers then scan forward from that offset through the index
+// file to land on id's own entry. A bloomFilteredIndexLookup wrapping
+// this type is what turns "nearest lower entry found" into a true/false
+// answer for IDs that were never written at all (see
+// bloomFilteredIndexLookup.getNearestIndexFileOffset).
+func (l *indexLookup) getNearestIndexFileOffset(id ts.ID) (int64, bool, error) {
+	idBytes := id.Data().Get()
+
+	_, value, ok := l.tree.Floor(idBytes)
+	if !ok {
+		return 0, false, nil
+	}
+
+	return value.indexOffset, true, nil
+}
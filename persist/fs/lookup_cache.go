@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultLookupCache is shared across all readers in the process so that
+// hot shards' trees stay resident even as individual FileSetReaders are
+// opened and closed around them. Its capacity defaults to
+// defaultIndexLookupCacheCapacity but is adjustable at runtime via
+// Options.SetIndexLookupCacheCapacity, since each indexLookup is
+// immutable once built and entries never need invalidating, only
+// evicting once the cache is over capacity.
+var defaultLookupCache = newIndexLookupCache(0)
+
+// indexLookupCacheKey identifies a single fileset's indexLookup. Shard
+// numbers are small integers reused across every namespace and every
+// time block, so shard alone is not a valid cache key: namespace and
+// block start must both be part of it, or two unrelated blocks that
+// happen to reuse a shard number would collide and silently hand back
+// the wrong block's radixTree/Bloom filter.
+type indexLookupCacheKey struct {
+	namespace string
+	shard     uint32
+	start     int64
+}
+
+// indexLookupCache is a size-bounded LRU of indexLookups keyed by
+// namespace/shard/block start, so that hot blocks' trees stay resident in
+// memory across reads instead of being rebuilt from the summaries file
+// every time.
+type indexLookupCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[indexLookupCacheKey]*list.Element
+}
+
+type indexLookupCacheEntry struct {
+	key    indexLookupCacheKey
+	lookup fileSetIndexLookup
+}
+
+// newIndexLookupCache returns an indexLookupCache holding at most
+// capacity entries. A non-positive capacity defaults to
+// defaultIndexLookupCacheCapacity.
+func newIndexLookupCache(capacity int) *indexLookupCache {
+	if capacity <= 0 {
+		capacity = defaultIndexLookupCacheCapacity
+	}
+	return &indexLookupCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[indexLookupCacheKey]*list.Element),
+	}
+}
+
+// Get returns the cached indexLookup for key, if any, and marks it as
+// most-recently-used.
+func (c *indexLookupCache) Get(key indexLookupCacheKey) (fileSetIndexLookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*indexLookupCacheEntry).lookup, true
+}
+
+// SetCapacity adjusts how many entries the cache may hold, evicting the
+// least-recently-used entries immediately if the new capacity is lower
+// than the current size. A non-positive capacity is ignored, since a
+// cache that could never hold anything defeats its own purpose.
+func (c *indexLookupCache) SetCapacity(capacity int) {
+	if capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*indexLookupCacheEntry).key)
+	}
+}
+
+// Put caches lookup for key, evicting the least-recently-used entry if
+// the cache is already at capacity.
+func (c *indexLookupCache) Put(key indexLookupCacheKey, lookup fileSetIndexLookup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*indexLookupCacheEntry).lookup = lookup
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	elem := c.ll.PushFront(&indexLookupCacheEntry{key: key, lookup: lookup})
+	c.items[key] = elem
+
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*indexLookupCacheEntry).key)
+		}
+	}
+}
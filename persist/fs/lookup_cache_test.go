@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexLookupCacheSetCapacityEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newIndexLookupCache(4)
+
+	for i := 0; i < 4; i++ {
+		key := indexLookupCacheKey{namespace: "ns", shard: uint32(i), start: 0}
+		c.Put(key, nil)
+	}
+
+	// Touch shard 0 so it is no longer the least-recently-used entry.
+	_, ok := c.Get(indexLookupCacheKey{namespace: "ns", shard: 0, start: 0})
+	assert.True(t, ok)
+
+	c.SetCapacity(1)
+
+	_, ok = c.Get(indexLookupCacheKey{namespace: "ns", shard: 0, start: 0})
+	assert.True(t, ok, "most recently used entry should survive shrinking the cache")
+
+	for i := 1; i < 4; i++ {
+		_, ok := c.Get(indexLookupCacheKey{namespace: "ns", shard: uint32(i), start: 0})
+		assert.False(t, ok, "entry for shard %d should have been evicted", i)
+	}
+}
+
+func TestIndexLookupCacheSetCapacityIgnoresNonPositiveValues(t *testing.T) {
+	c := newIndexLookupCache(4)
+	c.SetCapacity(0)
+	c.SetCapacity(-1)
+	assert.Equal(t, 4, c.capacity)
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NewMemFilesystem returns a Filesystem that keeps all file contents in
+// memory rather than touching disk. It is intended for use in fileset
+// tests (avoiding the ioutil.TempDir/os.RemoveAll dance) and as a base
+// for fault-injecting wrappers that need to simulate disk errors for the
+// index/summaries/data/checkpoint files.
+func NewMemFilesystem() Filesystem {
+	return &memFilesystem{
+		files: make(map[string]*memFileData),
+		dirs:  map[string]struct{}{"": {}},
+	}
+}
+
+type memFileData struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	modTime time.Time
+}
+
+type memFilesystem struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+	dirs  map[string]struct{}
+}
+
+func (fs *memFilesystem) Open(name string) (File, error) {
+	fs.mu.Lock()
+	data, ok := fs.files[name]
+	fs.mu.Unlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	data.mu.Lock()
+	contents := append([]byte(nil), data.buf.Bytes()...)
+	data.mu.Unlock()
+
+	return &memFile{
+		name:   name,
+		reader: bytes.NewReader(contents),
+		data:   data,
+	}, nil
+}
+
+func (fs *memFilesystem) Create(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.mkdirAllLocked(filepath.Dir(name), 0); err != nil {
+		return nil, err
+	}
+
+	data := &memFileData{modTime: memNow()}
+	fs.files[name] = data
+
+	return &memFile{name: name, data: data, writable: true}, nil
+}
+
+func (fs *memFilesystem) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if data, ok := fs.files[name]; ok {
+		data.mu.Lock()
+		size := int64(data.buf.Len())
+		modTime := data.modTime
+		data.mu.Unlock()
+		return memFileInfo{name: filepath.Base(name), size: size, modTime: modTime}, nil
+	}
+	if _, ok := fs.dirs[name]; ok {
+		return memFileInfo{name: filepath.Base(name), isDir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *memFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.mkdirAllLocked(path, perm)
+}
+
+func (fs *memFilesystem) mkdirAllLocked(path string, perm os.FileMode) error {
+	if path == "" || path == "." {
+		return nil
+	}
+	slashed := filepath.ToSlash(path)
+	parts := strings.Split(slashed, "/")
+	curr := ""
+	if strings.HasPrefix(slashed, "/") {
+		curr = "/"
+		fs.dirs[curr] = struct{}{}
+	}
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		if curr == "" || curr == "/" {
+			curr = curr + part
+		} else {
+			curr = curr + "/" + part
+		}
+		fs.dirs[curr] = struct{}{}
+	}
+	fs.dirs[path] = struct{}{}
+	return nil
+}
+
+func (fs *memFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.dirs[dirname]; !ok {
+		return nil, &os.PathError{Op: "open", Path: dirname, Err: os.ErrNotExist}
+	}
+
+	var infos []os.FileInfo
+	prefix := dirname + string(filepath.Separator)
+	for name, data := range fs.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(name, prefix)
+		if strings.Contains(rel, string(filepath.Separator)) {
+			continue
+		}
+		data.mu.Lock()
+		size := int64(data.buf.Len())
+		modTime := data.modTime
+		data.mu.Unlock()
+		infos = append(infos, memFileInfo{name: rel, size: size, modTime: modTime})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs *memFilesystem) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if _, ok := fs.dirs[name]; ok {
+		prefix := name + string(filepath.Separator)
+		for other := range fs.files {
+			if strings.HasPrefix(other, prefix) {
+				return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+			}
+		}
+		for other := range fs.dirs {
+			if strings.HasPrefix(other, prefix) {
+				return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+			}
+		}
+		delete(fs.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+type memFile struct {
+	name     string
+	reader   *bytes.Reader
+	data     *memFileData
+	writable bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+	f.data.modTime = memNow()
+	return f.data.buf.Write(p)
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+	return memFileInfo{
+		name:    filepath.Base(f.name),
+		size:    int64(f.data.buf.Len()),
+		modTime: f.data.modTime,
+	}, nil
+}
+
+func (f *memFile) Sync() error { return nil }
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+// memNow is a variable so that tests can fake out wall clock time if ever
+// needed; it otherwise just defers to time.Now.
+var memNow = time.Now
@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"github.com/m3db/m3db/persist/encoding/msgpack"
+)
+
+const (
+	defaultWriterBufferSize                     = 65536
+	defaultInfoReaderBufferSize                 = 64
+	defaultIndexSummariesPercent                = 0.03
+	defaultIndexBloomFilterFalsePositivePercent = 0.01
+)
+
+// defaultIndexLookupCacheCapacity bounds how many per-block indexLookups
+// (and therefore radixTrees) the process-wide defaultLookupCache keeps
+// resident across all namespaces/shards. 4 is deliberately small: it
+// covers a single node serving a handful of recently-flushed blocks
+// without holding every historical block's tree in memory, since each
+// tree is rebuilt cheaply from its summaries file on a cache miss.
+// Deployments with many concurrently hot blocks should raise this via
+// SetIndexLookupCacheCapacity.
+const defaultIndexLookupCacheCapacity = 4
+
+// Options control how fileset files are read from and written to. A new
+// Options should be constructed with NewOptions and customized via the
+// fluent Set* methods, mirroring the rest of the option types in this
+// codebase.
+type Options interface {
+	// SetFilesystem sets the Filesystem used for all fileset I/O. This
+	// defaults to an OS-backed Filesystem and only needs to be overridden
+	// to run against an in-memory, remote, or fault-injecting backend.
+	SetFilesystem(value Filesystem) Options
+
+	// Filesystem returns the Filesystem used for all fileset I/O.
+	Filesystem() Filesystem
+
+	// SetFilePathPrefix sets the file path prefix for fileset files.
+	SetFilePathPrefix(value string) Options
+
+	// FilePathPrefix returns the file path prefix for fileset files.
+	FilePathPrefix() string
+
+	// SetWriterBufferSize sets the buffer size used by the FileSetWriter.
+	SetWriterBufferSize(value int) Options
+
+	// WriterBufferSize returns the buffer size used by the FileSetWriter.
+	WriterBufferSize() int
+
+	// SetInfoReaderBufferSize sets the buffer size used for reading the
+	// info file and computing its digest.
+	SetInfoReaderBufferSize(value int) Options
+
+	// InfoReaderBufferSize returns the buffer size used for reading the
+	// info file and computing its digest.
+	InfoReaderBufferSize() int
+
+	// SetIndexSummariesPercent sets the percentage of entries in the index
+	// file that will also be written to the summaries file.
+	SetIndexSummariesPercent(value float64) Options
+
+	// IndexSummariesPercent returns the percentage of entries in the index
+	// file that will also be written to the summaries file.
+	IndexSummariesPercent() float64
+
+	// SetDecodingOptions sets the msgpack decoding options.
+	SetDecodingOptions(value msgpack.DecodingOptions) Options
+
+	// DecodingOptions returns the msgpack decoding options.
+	DecodingOptions() msgpack.DecodingOptions
+
+	// SetIndexBloomFilterFalsePositivePercent sets the target false
+	// positive rate used to size the per-block Bloom filter sidecar
+	// written alongside the index/summaries/data files.
+	SetIndexBloomFilterFalsePositivePercent(value float64) Options
+
+	// IndexBloomFilterFalsePositivePercent returns the target false
+	// positive rate used to size the per-block Bloom filter sidecar.
+	IndexBloomFilterFalsePositivePercent() float64
+
+	// SetContentAddressable sets whether the FileSetWriter dedupes
+	// byte-identical encoded blocks within a shard/block, writing only an
+	// index entry pointing at the existing data file offset for every
+	// write after the first instead of appending the bytes again.
+	SetContentAddressable(value bool) Options
+
+	// ContentAddressable returns whether the FileSetWriter dedupes
+	// byte-identical encoded blocks within a shard/block.
+	ContentAddressable() bool
+
+	// SetIndexLookupCacheCapacity sets how many indexLookups the process-wide
+	// defaultLookupCache keeps resident across every namespace/shard/block.
+	SetIndexLookupCacheCapacity(value int) Options
+
+	// IndexLookupCacheCapacity returns how many indexLookups the
+	// process-wide defaultLookupCache keeps resident across every
+	// namespace/shard/block.
+	IndexLookupCacheCapacity() int
+}
+
+type options struct {
+	filesystem                           Filesystem
+	filePathPrefix                       string
+	writerBufferSize                     int
+	infoReaderBufferSize                 int
+	indexSummariesPercent                float64
+	decodingOptions                      msgpack.DecodingOptions
+	indexBloomFilterFalsePositivePercent float64
+	contentAddressable                   bool
+	indexLookupCacheCapacity             int
+}
+
+// NewOptions creates a new set of fileset options, defaulting the
+// Filesystem to the local disk.
+func NewOptions() Options {
+	return &options{
+		filesystem:                           NewOSFilesystem(),
+		writerBufferSize:                     defaultWriterBufferSize,
+		infoReaderBufferSize:                 defaultInfoReaderBufferSize,
+		indexSummariesPercent:                defaultIndexSummariesPercent,
+		decodingOptions:                      msgpack.NewDecodingOptions(),
+		indexBloomFilterFalsePositivePercent: defaultIndexBloomFilterFalsePositivePercent,
+		indexLookupCacheCapacity:             defaultIndexLookupCacheCapacity,
+	}
+}
+
+func (o *options) SetFilesystem(value Filesystem) Options {
+	opts := *o
+	opts.filesystem = value
+	return &opts
+}
+
+func (o *options) Filesystem() Filesystem {
+	return o.filesystem
+}
+
+func (o *options) SetFilePathPrefix(value string) Options {
+	opts := *o
+	opts.filePathPrefix = value
+	return &opts
+}
+
+func (o *options) FilePathPrefix() string {
+	return o.filePathPrefix
+}
+
+func (o *options) SetWriterBufferSize(value int) Options {
+	opts := *o
+	opts.writerBufferSize = value
+	return &opts
+}
+
+func (o *options) WriterBufferSize() int {
+	return o.writerBufferSize
+}
+
+func (o *options) SetInfoReaderBufferSize(value int) Options {
+	opts := *o
+	opts.infoReaderBufferSize = value
+	return &opts
+}
+
+func (o *options) InfoReaderBufferSize() int {
+	return o.infoReaderBufferSize
+}
+
+func (o *options) SetIndexSummariesPercent(value float64) Options {
+	opts := *o
+	opts.indexSummariesPercent = value
+	return &opts
+}
+
+func (o *options) IndexSummariesPercent() float64 {
+	return o.indexSummariesPercent
+}
+
+func (o *options) SetDecodingOptions(value msgpack.DecodingOptions) Options {
+	opts := *o
+	opts.decodingOptions = value
+	return &opts
+}
+
+func (o *options) DecodingOptions() msgpack.DecodingOptions {
+	return o.decodingOptions
+}
+
+func (o *options) SetIndexBloomFilterFalsePositivePercent(value float64) Options {
+	opts := *o
+	opts.indexBloomFilterFalsePositivePercent = value
+	return &opts
+}
+
+func (o *options) IndexBloomFilterFalsePositivePercent() float64 {
+	return o.indexBloomFilterFalsePositivePercent
+}
+
+func (o *options) SetContentAddressable(value bool) Options {
+	opts := *o
+	opts.contentAddressable = value
+	return &opts
+}
+
+func (o *options) ContentAddressable() bool {
+	return o.contentAddressable
+}
+
+func (o *options) SetIndexLookupCacheCapacity(value int) Options {
+	opts := *o
+	opts.indexLookupCacheCapacity = value
+	return &opts
+}
+
+func (o *options) IndexLookupCacheCapacity() int {
+	return o.indexLookupCacheCapacity
+}
@@ -0,0 +1,368 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import "bytes"
+
+// radixValue is the payload stored at each leaf of a radixTree. Trees built
+// by readIndexLookupFromSummariesFile fill in only indexOffset, since a
+// summaries entry carries no data file offset, size or checksum; those
+// three fields are left zero there.
+type radixValue struct {
+	indexOffset int64
+	dataOffset  int64
+	size        int64
+	checksum    uint32
+}
+
+// radixTree is a persistent (immutable) radix tree, modeled on
+// hashicorp/go-immutable-radix: every Insert returns a new root that
+// shares unmodified structure with the old one, so a reader holding an
+// older root never observes a concurrent writer's in-progress Insert.
+// This is what lets a fileset flush publish a fresh tree atomically
+// without taking a lock against in-flight reads, and makes snapshotting
+// a tree for repair/verification free (just keep the old root around).
+type radixTree struct {
+	root *radixNode
+}
+
+type radixNode struct {
+	// prefix is the edge label leading to this node from its parent.
+	prefix []byte
+	// leaf is non-nil if a key terminates at this node.
+	leaf *radixLeaf
+	// edges is sorted by the first byte of each child's prefix.
+	edges []radixEdge
+}
+
+type radixLeaf struct {
+	key   []byte
+	value radixValue
+}
+
+type radixEdge struct {
+	label byte
+	node  *radixNode
+}
+
+// newRadixTree returns an empty radixTree.
+func newRadixTree() *radixTree {
+	return &radixTree{root: &radixNode{}}
+}
+
+// Get performs an exact-match lookup of key, returning ok=false (with no
+// error) if key was never inserted -- this is the "cleanly say no such
+// key" property the old summaries-only binary search could not provide.
+func (t *radixTree) Get(key []byte) (radixValue, bool) {
+	n := t.root
+	search := key
+	for {
+		if len(search) == 0 {
+			if n.leaf != nil {
+				return n.leaf.value, true
+			}
+			return radixValue{}, false
+		}
+
+		edge := n.findEdge(search[0])
+		if edge == nil {
+			return radixValue{}, false
+		}
+		n = edge.node
+		if !bytes.HasPrefix(search, n.prefix) {
+			return radixValue{}, false
+		}
+		search = search[len(n.prefix):]
+	}
+}
+
+// LongestPrefix returns the longest key in the tree that is a prefix of
+// the given search key, e.g. for tag/namespace prefix scans in the query
+// layer. It returns ok=false if no key in the tree is a prefix of search.
+func (t *radixTree) LongestPrefix(search []byte) ([]byte, radixValue, bool) {
+	var lastKey []byte
+	var lastValue radixValue
+	found := false
+
+	n := t.root
+	remaining := search
+	for {
+		if n.leaf != nil {
+			lastKey, lastValue, found = n.leaf.key, n.leaf.value, true
+		}
+
+		if len(remaining) == 0 {
+			break
+		}
+
+		edge := n.findEdge(remaining[0])
+		if edge == nil {
+			break
+		}
+		n = edge.node
+		if !bytes.HasPrefix(remaining, n.prefix) {
+			break
+		}
+		remaining = remaining[len(n.prefix):]
+	}
+
+	return lastKey, lastValue, found
+}
+
+// Iter invokes fn for every key in the tree with the given prefix, in
+// sorted order, stopping early if fn returns false. This backs
+// tag/namespace prefix range scans.
+func (t *radixTree) Iter(prefix []byte, fn func(key []byte, value radixValue) bool) {
+	n, rem := t.root.seek(prefix)
+	if n == nil {
+		return
+	}
+	_ = rem
+	n.walk(fn)
+}
+
+// Insert returns a new radixTree with key mapped to value, sharing all
+// unmodified structure with t.
+func (t *radixTree) Insert(key []byte, value radixValue) *radixTree {
+	newRoot := t.root.insert(key, key, value)
+	return &radixTree{root: newRoot}
+}
+
+// Floor returns the greatest key in the tree that is lexicographically <=
+// search, along with its value, or ok=false if every key in the tree
+// sorts above search. This is the "nearest lower key" query
+// getNearestIndexFileOffset actually needs: summary entries are a sparse
+// sample of the full ID space, so a lookup for an unsampled (but
+// genuinely written) ID must fall back to the closest preceding sampled
+// entry instead of reporting "not found".
+func (t *radixTree) Floor(search []byte) ([]byte, radixValue, bool) {
+	return t.root.floor(search)
+}
+
+func (n *radixNode) findEdge(label byte) *radixEdge {
+	for i := range n.edges {
+		if n.edges[i].label == label {
+			return &n.edges[i]
+		}
+	}
+	return nil
+}
+
+// seek walks down to the node whose subtree holds every key with the
+// given prefix (or nil if no such key exists).
+func (n *radixNode) seek(prefix []byte) (*radixNode, []byte) {
+	search := prefix
+	curr := n
+	for len(search) > 0 {
+		edge := curr.findEdge(search[0])
+		if edge == nil {
+			return nil, nil
+		}
+
+		switch {
+		case bytes.HasPrefix(search, edge.node.prefix):
+			search = search[len(edge.node.prefix):]
+			curr = edge.node
+		case bytes.HasPrefix(edge.node.prefix, search):
+			// The requested prefix ends partway through this edge; every
+			// key below it still matches.
+			return edge.node, nil
+		default:
+			return nil, nil
+		}
+	}
+	return curr, nil
+}
+
+// floor finds the greatest key reachable from n that is <= search, where
+// search has already had every edge prefix on the path down to n
+// stripped off.
+func (n *radixNode) floor(search []byte) ([]byte, radixValue, bool) {
+	if len(search) == 0 {
+		// The only key <= "" from here is n's own leaf, if it has one;
+		// every key reachable via an edge is strictly longer (hence
+		// greater).
+		if n.leaf != nil {
+			return n.leaf.key, n.leaf.value, true
+		}
+		return nil, radixValue{}, false
+	}
+
+	edge := n.findEdge(search[0])
+	if edge == nil {
+		return n.predecessorBefore(search[0])
+	}
+
+	child := edge.node
+	switch {
+	case bytes.HasPrefix(search, child.prefix):
+		if key, value, ok := child.floor(search[len(child.prefix):]); ok {
+			return key, value, true
+		}
+		// Nothing under child is <= the remaining search; fall back to
+		// the nearest sibling edge below it, or n's own leaf.
+		return n.predecessorBefore(search[0])
+	case bytes.Compare(search, child.prefix) < 0:
+		// search sorts below every key in child's subtree.
+		return n.predecessorBefore(search[0])
+	default:
+		// search and child.prefix diverge with search sorting higher:
+		// every key under child is < search, and child is the edge
+		// closest to search, so its maximum key is the floor.
+		return child.maxKey()
+	}
+}
+
+// predecessorBefore returns the greatest key reachable from n via an edge
+// labeled strictly less than exclude, falling back to n's own leaf if no
+// such edge exists.
+func (n *radixNode) predecessorBefore(exclude byte) ([]byte, radixValue, bool) {
+	for i := len(n.edges) - 1; i >= 0; i-- {
+		if n.edges[i].label < exclude {
+			return n.edges[i].node.maxKey()
+		}
+	}
+	if n.leaf != nil {
+		return n.leaf.key, n.leaf.value, true
+	}
+	return nil, radixValue{}, false
+}
+
+// maxKey returns the lexicographically greatest key in n's subtree, by
+// always descending the last (highest-labeled) edge -- edges are kept
+// sorted ascending, and every key under a child is greater than n's own
+// leaf, so the rightmost path always leads to the maximum.
+func (n *radixNode) maxKey() ([]byte, radixValue, bool) {
+	curr := n
+	for len(curr.edges) > 0 {
+		curr = curr.edges[len(curr.edges)-1].node
+	}
+	if curr.leaf != nil {
+		return curr.leaf.key, curr.leaf.value, true
+	}
+	return nil, radixValue{}, false
+}
+
+func (n *radixNode) walk(fn func(key []byte, value radixValue) bool) bool {
+	if n.leaf != nil {
+		if !fn(n.leaf.key, n.leaf.value) {
+			return false
+		}
+	}
+	for _, edge := range n.edges {
+		if !edge.node.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// insert returns a new subtree rooted where n was, with fullKey mapped to
+// value, copying only the nodes along the insertion path. remaining is
+// the suffix of fullKey still unmatched at this depth (edge prefixes
+// consumed so far have been stripped off); fullKey itself is threaded
+// through every recursive call unchanged so that every radixLeaf stores
+// the complete original key rather than whatever suffix happened to
+// remain when the leaf was created.
+func (n *radixNode) insert(fullKey, remaining []byte, value radixValue) *radixNode {
+	if len(remaining) == 0 {
+		clone := n.clone()
+		clone.leaf = &radixLeaf{key: fullKey, value: value}
+		return clone
+	}
+
+	edge := n.findEdge(remaining[0])
+	if edge == nil {
+		clone := n.clone()
+		clone.edges = append(clone.edges, radixEdge{
+			label: remaining[0],
+			node:  &radixNode{prefix: remaining, leaf: &radixLeaf{key: fullKey, value: value}},
+		})
+		clone.sortEdges()
+		return clone
+	}
+
+	child := edge.node
+	common := commonPrefixLen(child.prefix, remaining)
+
+	clone := n.clone()
+	cloneEdge := clone.findEdge(remaining[0])
+
+	switch {
+	case common == len(child.prefix) && common == len(remaining):
+		// Exact match on this edge's prefix: overwrite its leaf.
+		newChild := child.clone()
+		newChild.leaf = &radixLeaf{key: fullKey, value: value}
+		cloneEdge.node = newChild
+
+	case common == len(child.prefix):
+		// child.prefix is a strict prefix of remaining: recurse further down.
+		cloneEdge.node = child.insert(fullKey, remaining[common:], value)
+
+	default:
+		// Need to split the edge at the common prefix.
+		split := &radixNode{prefix: child.prefix[:common]}
+		remainderChild := child.clone()
+		remainderChild.prefix = child.prefix[common:]
+		split.edges = []radixEdge{{label: remainderChild.prefix[0], node: remainderChild}}
+
+		if common == len(remaining) {
+			split.leaf = &radixLeaf{key: fullKey, value: value}
+		} else {
+			newLeafPrefix := remaining[common:]
+			split.edges = append(split.edges, radixEdge{
+				label: newLeafPrefix[0],
+				node:  &radixNode{prefix: newLeafPrefix, leaf: &radixLeaf{key: fullKey, value: value}},
+			})
+		}
+		split.sortEdges()
+		cloneEdge.node = split
+	}
+
+	return clone
+}
+
+func (n *radixNode) clone() *radixNode {
+	clone := &radixNode{prefix: n.prefix, leaf: n.leaf}
+	clone.edges = append(clone.edges, n.edges...)
+	return clone
+}
+
+func (n *radixNode) sortEdges() {
+	edges := n.edges
+	for i := 1; i < len(edges); i++ {
+		for j := i; j > 0 && edges[j-1].label > edges[j].label; j-- {
+			edges[j-1], edges[j] = edges[j], edges[j-1]
+		}
+	}
+}
+
+func commonPrefixLen(a, b []byte) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+	i := 0
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return i
+}
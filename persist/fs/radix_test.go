@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRadixTreeGetReturnsExactValue(t *testing.T) {
+	tree := newRadixTree()
+	tree = tree.Insert([]byte("b"), radixValue{dataOffset: 1})
+	tree = tree.Insert([]byte("bba"), radixValue{dataOffset: 2})
+	tree = tree.Insert([]byte("bbaa"), radixValue{dataOffset: 3})
+	tree = tree.Insert([]byte("bbbb"), radixValue{dataOffset: 4})
+
+	value, ok := tree.Get([]byte("bbaa"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), value.dataOffset)
+
+	_, ok = tree.Get([]byte("bbba"))
+	assert.False(t, ok)
+}
+
+// TestRadixTreeFloorReturnsFullInsertedKey reproduces a bug where every
+// radixLeaf stored whatever suffix of the key remained at its insertion
+// recursion depth instead of the full original key: inserting "b", "bba",
+// "bbaa", "bbbb" and calling Floor("bbba") used to return the key "a"
+// (not even a member of the tree) instead of the correct predecessor
+// "bbaa".
+func TestRadixTreeFloorReturnsFullInsertedKey(t *testing.T) {
+	tree := newRadixTree()
+	tree = tree.Insert([]byte("b"), radixValue{dataOffset: 1})
+	tree = tree.Insert([]byte("bba"), radixValue{dataOffset: 2})
+	tree = tree.Insert([]byte("bbaa"), radixValue{dataOffset: 3})
+	tree = tree.Insert([]byte("bbbb"), radixValue{dataOffset: 4})
+
+	key, value, ok := tree.Floor([]byte("bbba"))
+	assert.True(t, ok)
+	assert.Equal(t, "bbaa", string(key))
+	assert.Equal(t, int64(3), value.dataOffset)
+}
+
+func TestRadixTreeLongestPrefixReturnsFullInsertedKey(t *testing.T) {
+	tree := newRadixTree()
+	tree = tree.Insert([]byte("b"), radixValue{dataOffset: 1})
+	tree = tree.Insert([]byte("bba"), radixValue{dataOffset: 2})
+	tree = tree.Insert([]byte("bbaa"), radixValue{dataOffset: 3})
+
+	key, value, ok := tree.LongestPrefix([]byte("bbaaxyz"))
+	assert.True(t, ok)
+	assert.Equal(t, "bbaa", string(key))
+	assert.Equal(t, int64(3), value.dataOffset)
+
+	key, value, ok = tree.LongestPrefix([]byte("bbax"))
+	assert.True(t, ok)
+	assert.Equal(t, "bba", string(key))
+	assert.Equal(t, int64(2), value.dataOffset)
+}
+
+func TestRadixTreeIterReturnsFullInsertedKeys(t *testing.T) {
+	tree := newRadixTree()
+	tree = tree.Insert([]byte("b"), radixValue{dataOffset: 1})
+	tree = tree.Insert([]byte("bba"), radixValue{dataOffset: 2})
+	tree = tree.Insert([]byte("bbaa"), radixValue{dataOffset: 3})
+	tree = tree.Insert([]byte("bbbb"), radixValue{dataOffset: 4})
+
+	var gotKeys []string
+	tree.Iter([]byte("bb"), func(key []byte, value radixValue) bool {
+		gotKeys = append(gotKeys, string(key))
+		return true
+	})
+
+	assert.Equal(t, []string{"bba", "bbaa", "bbbb"}, gotKeys)
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/m3db/m3db/digest"
+	"github.com/m3db/m3db/persist/encoding"
+	"github.com/m3db/m3db/persist/encoding/msgpack"
+	"github.com/m3db/m3db/ts"
+
+	"github.com/m3db/m3x/checked"
+)
+
+// FileSetReader reads the data, index, summaries and info files written
+// out by a FileSetWriter for a single shard/block.
+type FileSetReader interface {
+	// Open opens the fileset files for the given namespace/shard/block
+	// for reading.
+	Open(namespace ts.ID, shard uint32, start time.Time) error
+
+	// Read returns the next id and its encoded data in index order, or
+	// io.EOF once every entry has been returned.
+	Read() (id ts.ID, data checked.Bytes, checksum uint32, err error)
+
+	// Close closes all the files opened by Open.
+	Close() error
+}
+
+type reader struct {
+	options Options
+	fs      Filesystem
+
+	infoFd      File
+	dataFd      File
+	indexFd     File
+	summariesFd File
+	bloomFd     File
+
+	decoder            *msgpack.Decoder
+	indexDecoderStream encoding.DecoderStream
+	dataBytes          []byte
+
+	indexLookup fileSetIndexLookup
+}
+
+// NewReader returns a new FileSetReader backed by the Filesystem
+// configured on opts (an OS-backed Filesystem by default).
+func NewReader(opts Options) (FileSetReader, error) {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	return &reader{
+		options: opts,
+		fs:      opts.Filesystem(),
+		decoder: msgpack.NewDecoder(opts.DecodingOptions()),
+	}, nil
+}
+
+func (r *reader) Open(namespace ts.ID, shard uint32, start time.Time) error {
+	shardDir := ShardDirPath(r.options.FilePathPrefix(), namespace, shard)
+
+	var err error
+	if r.infoFd, err = r.fs.Open(filesetPathFromTime(shardDir, start, infoFileSuffix)); err != nil {
+		return err
+	}
+	infoBytes, err := ioutil.ReadAll(r.infoFd)
+	if err != nil {
+		return fmt.Errorf("err reading info file: %v", err)
+	}
+	digests, err := readInfoFileDigests(infoBytes)
+	if err != nil {
+		return fmt.Errorf("err parsing info file: %v", err)
+	}
+
+	if r.dataFd, err = r.fs.Open(filesetPathFromTime(shardDir, start, dataFileSuffix)); err != nil {
+		return err
+	}
+	dataDigest := digest.NewFdWithDigestReader(r.options.InfoReaderBufferSize())
+	dataDigest.Reset(r.dataFd)
+	r.dataBytes, err = dataDigest.ReadAllAndValidate(digests.dataDigest)
+	if err != nil {
+		return fmt.Errorf("err validating data file digest: %v", err)
+	}
+
+	if r.indexFd, err = r.fs.Open(filesetPathFromTime(shardDir, start, indexFileSuffix)); err != nil {
+		return err
+	}
+	indexDigest := digest.NewFdWithDigestReader(r.options.InfoReaderBufferSize())
+	indexDigest.Reset(r.indexFd)
+	indexBytes, err := indexDigest.ReadAllAndValidate(digests.indexDigest)
+	if err != nil {
+		return fmt.Errorf("err validating index file digest: %v", err)
+	}
+	r.indexDecoderStream = encoding.NewDecoderStream(indexBytes)
+
+	if r.summariesFd, err = r.fs.Open(filesetPathFromTime(shardDir, start, summariesFileSuffix)); err != nil {
+		return err
+	}
+	if r.bloomFd, err = r.fs.Open(filesetPathFromTime(shardDir, start, bloomFilterFileSuffix)); err != nil {
+		return err
+	}
+
+	cacheKey := indexLookupCacheKey{
+		namespace: namespace.String(),
+		shard:     shard,
+		start:     start.UnixNano(),
+	}
+
+	// r.decoder is shared between building the summaries lookup below and
+	// Read()'s sequential decoding of the index file; readIndexLookupFromSummariesFile
+	// points it at the summaries bytes, so it must be pointed back at
+	// r.indexDecoderStream before Open returns.
+	defer r.decoder.Reset(r.indexDecoderStream)
+
+	defaultLookupCache.SetCapacity(r.options.IndexLookupCacheCapacity())
+
+	if lookup, ok := defaultLookupCache.Get(cacheKey); ok {
+		r.indexLookup = lookup
+		return nil
+	}
+
+	summariesDigest := digest.NewFdWithDigestReader(r.options.InfoReaderBufferSize())
+	lookup, err := readIndexLookupFromSummariesFile(
+		r.summariesFd, summariesDigest, digests.summariesDigest, r.decoder, 0)
+	if err != nil {
+		return err
+	}
+
+	bloomDigest := digest.NewFdWithDigestReader(r.options.InfoReaderBufferSize())
+	filtered, err := newBloomFilteredIndexLookup(lookup, r.bloomFd, bloomDigest, digests.bloomDigest)
+	if err != nil {
+		return err
+	}
+
+	r.indexLookup = filtered
+	defaultLookupCache.Put(cacheKey, filtered)
+
+	return nil
+}
+
+func (r *reader) Read() (ts.ID, checked.Bytes, uint32, error) {
+	entry, err := r.decoder.DecodeIndexEntry()
+	if err != nil {
+		return nil, nil, 0, io.EOF
+	}
+
+	if entry.Offset < 0 || entry.Offset+entry.Size > int64(len(r.dataBytes)) {
+		return nil, nil, 0, fmt.Errorf(
+			"data entry for: %s has an out of range offset/size", entry.ID)
+	}
+
+	// r.dataBytes is read once in Open and never mutated afterwards, so
+	// aliasing its backing array here (rather than copying) is safe for
+	// the lifetime of this reader.
+	data := checked.NewBytes(r.dataBytes[entry.Offset:entry.Offset+entry.Size], nil)
+	data.IncRef()
+
+	id := checked.NewBytes(entry.ID, nil)
+	id.IncRef()
+
+	return ts.BinaryID(id), data, uint32(entry.Checksum), nil
+}
+
+func (r *reader) Close() error {
+	for _, fd := range []File{r.infoFd, r.dataFd, r.indexFd, r.summariesFd, r.bloomFd} {
+		if fd == nil {
+			continue
+		}
+		if err := fd.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3db/digest"
+	"github.com/m3db/m3db/ts"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReaderOpenCacheKeyIncludesNamespaceAndStart guards against the
+// lookup cache being keyed by shard alone: shard numbers are small
+// integers reused across every namespace and every time block, so two
+// unrelated blocks that happen to share a shard number must not collide
+// in defaultLookupCache and hand back each other's indexLookup.
+func TestReaderOpenCacheKeyIncludesNamespaceAndStart(t *testing.T) {
+	memFs := NewMemFilesystem()
+	filePathPrefix := "testdb"
+	var shard uint32 = 3
+
+	nsA := ts.StringID("ns-a")
+	nsB := ts.StringID("ns-b")
+	startA := testWriterStart
+	startB := testWriterStart.Add(testBlockSize)
+
+	writeOne := func(namespace ts.ID, start time.Time, id string, payload []byte) {
+		options := NewOptions().
+			SetFilePathPrefix(filePathPrefix).
+			SetWriterBufferSize(testWriterBufferSize).
+			SetFilesystem(memFs)
+
+		w, err := NewWriter(options)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Open(namespace, testBlockSize, shard, start))
+		assert.NoError(t, w.Write(ts.StringID(id), bytesRefd(payload), digest.Checksum(payload)))
+		assert.NoError(t, w.Close())
+	}
+
+	writeOne(nsA, startA, "only-in-a", testPayloads[0])
+	writeOne(nsB, startB, "only-in-b", testPayloads[1])
+
+	options := NewOptions().
+		SetFilePathPrefix(filePathPrefix).
+		SetFilesystem(memFs)
+
+	rA, err := NewReader(options)
+	assert.NoError(t, err)
+	assert.NoError(t, rA.Open(nsA, shard, startA))
+
+	rB, err := NewReader(options)
+	assert.NoError(t, err)
+	assert.NoError(t, rB.Open(nsB, shard, startB))
+
+	lookupA := rA.(*reader).indexLookup
+	lookupB := rB.(*reader).indexLookup
+
+	_, ok, err := lookupA.getNearestIndexFileOffset(ts.StringID("only-in-a"))
+	assert.NoError(t, err)
+	assert.True(t, ok, "ns-a's reader should find an id it actually wrote")
+
+	_, ok, err = lookupB.getNearestIndexFileOffset(ts.StringID("only-in-b"))
+	assert.NoError(t, err)
+	assert.True(t, ok, "ns-b's reader should find an id it actually wrote")
+
+	// If the cache were still keyed by shard alone, rB.Open would have
+	// returned ns-a's cached lookup instead of building its own, and this
+	// would incorrectly report true.
+	_, ok, err = lookupB.getNearestIndexFileOffset(ts.StringID("only-in-a"))
+	assert.NoError(t, err)
+	assert.False(t, ok, "ns-b's reader must not resolve an id that was only ever written to ns-a's block")
+}
+
+// TestReaderOpenDetectsBloomFilterCorruption confirms that Open actually
+// validates the Bloom filter sidecar against the digest persisted in the
+// info file, rather than the hardcoded expectedDigest=0 that made every
+// digest check vacuously pass.
+func TestReaderOpenDetectsBloomFilterCorruption(t *testing.T) {
+	memFs := NewMemFilesystem()
+	filePathPrefix := "testdb"
+	namespace := ts.StringID("ns-corrupt")
+	var shard uint32 = 9
+
+	options := NewOptions().
+		SetFilePathPrefix(filePathPrefix).
+		SetWriterBufferSize(testWriterBufferSize).
+		SetFilesystem(memFs)
+
+	w, err := NewWriter(options)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Open(namespace, testBlockSize, shard, testWriterStart))
+	assert.NoError(t, w.Write(ts.StringID("an-id"), bytesRefd(testPayloads[0]), digest.Checksum(testPayloads[0])))
+	assert.NoError(t, w.Close())
+
+	shardDirPath := ShardDirPath(filePathPrefix, namespace, shard)
+	bloomFilePath := filesetPathFromTime(shardDirPath, testWriterStart, bloomFilterFileSuffix)
+
+	corrupted, err := memFs.Create(bloomFilePath)
+	assert.NoError(t, err)
+	_, err = corrupted.Write([]byte("not a valid bloom filter sidecar"))
+	assert.NoError(t, err)
+
+	r, err := NewReader(options)
+	assert.NoError(t, err)
+	assert.Error(t, r.Open(namespace, shard, testWriterStart))
+}
+
+// TestReaderReadReturnsEveryEntryInIndexOrder exercises Read()'s
+// sequential decode of the index/data files in tandem, which used to be
+// a permanent stub returning (nil, nil, 0, nil).
+func TestReaderReadReturnsEveryEntryInIndexOrder(t *testing.T) {
+	memFs := NewMemFilesystem()
+	filePathPrefix := "testdb"
+	namespace := ts.StringID("ns-read")
+	var shard uint32 = 5
+
+	options := NewOptions().
+		SetFilePathPrefix(filePathPrefix).
+		SetWriterBufferSize(testWriterBufferSize).
+		SetFilesystem(memFs)
+
+	ids := []string{"id-0000", "id-0001", "id-0002"}
+	w, err := NewWriter(options)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Open(namespace, testBlockSize, shard, testWriterStart))
+	for i, id := range ids {
+		payload := testPayloads[i%len(testPayloads)]
+		assert.NoError(t, w.Write(ts.StringID(id), bytesRefd(payload), digest.Checksum(payload)))
+	}
+	assert.NoError(t, w.Close())
+
+	r, err := NewReader(options)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Open(namespace, shard, testWriterStart))
+
+	for i, expectedID := range ids {
+		id, data, checksum, err := r.Read()
+		assert.NoError(t, err, "unexpected error reading entry %d", i)
+		assert.Equal(t, expectedID, id.String())
+		payload := testPayloads[i%len(testPayloads)]
+		assert.Equal(t, payload, data.Get())
+		assert.Equal(t, digest.Checksum(payload), checksum)
+	}
+
+	_, _, _, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+}
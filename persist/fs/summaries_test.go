@@ -23,8 +23,6 @@ package fs
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
-	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -57,20 +55,34 @@ func TestIndexLookupWriteRead(t *testing.T) {
 			writes = append(writes, write)
 		}
 
-		// Create a temporary directory for each test run
-		dir, err := ioutil.TempDir("", "testdb")
-		if err != nil {
-			return false, err
+		// FileSetWriter.Write requires IDs in sorted order, and the
+		// nearest-lower-key assertions below only hold when they are.
+		for i := 1; i < len(writes); i++ {
+			for j := i; j > 0 && string(writes[j-1].id.Data().Get()) > string(writes[j].id.Data().Get()); j-- {
+				writes[j-1], writes[j] = writes[j], writes[j-1]
+			}
 		}
-		filePathPrefix := filepath.Join(dir, "")
-		defer os.RemoveAll(dir)
+
+		// Back the fileset with an in-memory filesystem so each property
+		// run gets a fresh, isolated "disk" without the ioutil.TempDir
+		// and defer os.RemoveAll dance.
+		memFs := NewMemFilesystem()
+		filePathPrefix := "testdb"
 
 		options := NewOptions().
-			// Make sure that every index entry is also in the summaries file for the
-			// sake of verifying behavior
-			SetIndexSummariesPercent(1).
+			// Vary the summaries sampling rate instead of pinning it at 1: at
+			// 1, every ID has an exact summaries entry and
+			// getNearestIndexFileOffset's fallback to the nearest lower sampled
+			// entry is never exercised, which is exactly the condition the
+			// feature exists for.
+			SetIndexSummariesPercent(input.indexSummariesPercent).
 			SetFilePathPrefix(filePathPrefix).
-			SetWriterBufferSize(testWriterBufferSize)
+			SetWriterBufferSize(testWriterBufferSize).
+			SetFilesystem(memFs).
+			// genWrite draws from a small pool of fixed payloads, so most
+			// runs contain colliding writes; enabling dedup here means we
+			// also exercise the content-addressable path on every run.
+			SetContentAddressable(true)
 		shard := input.shard
 
 		// Instantiate a writer and write the test data
@@ -90,29 +102,102 @@ func TestIndexLookupWriteRead(t *testing.T) {
 
 		// Figure out the offsets for the writes so we have something to compare
 		// our results against
-		expectedIndexFileOffsets, err := readIndexFileOffsets(
-			shardDirPath, len(writes), testWriterStart)
+		expectedEntries, err := readIndexFileOffsets(
+			memFs, shardDirPath, len(writes), testWriterStart)
 		if err != nil {
 			return false, fmt.Errorf("err reading index file offsets: %v", err)
 		}
+		expectedIndexFileOffsets := map[string]int64{}
+		for id, entry := range expectedEntries {
+			expectedIndexFileOffsets[id] = entry.indexFileOffset
+		}
+
+		// Since genWrite draws from a small pool of fixed payloads, any run
+		// with more writes than payloads is guaranteed to contain
+		// byte-identical blocks, so the data file written with dedup
+		// enabled should never be larger than one copy of each distinct
+		// payload actually used.
+		distinctPayloads := map[uint32]struct{}{}
+		for _, write := range writes {
+			distinctPayloads[write.checksum] = struct{}{}
+		}
+		dataFilePath := filesetPathFromTime(
+			shardDirPath, testWriterStart, dataFileSuffix)
+		dataFile, err := memFs.Open(dataFilePath)
+		if err != nil {
+			return false, fmt.Errorf("err opening data file: %v, ", err)
+		}
+		dataBytes, err := ioutil.ReadAll(dataFile)
+		if err != nil {
+			return false, fmt.Errorf("err reading data file: %v, ", err)
+		}
+		if maxDataLen := len(distinctPayloads) * 100; len(dataBytes) > maxDataLen {
+			return false, fmt.Errorf(
+				"data file is %d bytes, expected at most %d bytes worth of distinct payloads",
+				len(dataBytes), maxDataLen)
+		}
+
+		// Every ID, including deduped ones, must still resolve to the
+		// correct bytes and checksum at its recorded data file offset.
+		for id, entry := range expectedEntries {
+			if entry.size < 0 || entry.dataOffset+entry.size > int64(len(dataBytes)) {
+				return false, fmt.Errorf("data entry for: %s has an out of range offset/size", id)
+			}
+			actual := dataBytes[entry.dataOffset : entry.dataOffset+entry.size]
+			if digest.Checksum(actual) != entry.checksum {
+				return false, fmt.Errorf(
+					"data for: %s does not match its checksum at offset %d", id, entry.dataOffset)
+			}
+		}
 
 		// Read the summaries file into memory
 		summariesFilePath := filesetPathFromTime(
 			shardDirPath, testWriterStart, summariesFileSuffix)
-		summariesFile, err := os.Open(summariesFilePath)
+		summariesFile, err := memFs.Open(summariesFilePath)
 		if err != nil {
 			return false, fmt.Errorf("err opening summaries file: %v, ", err)
 		}
 		summariesFdWithDigest := digest.NewFdWithDigestReader(options.InfoReaderBufferSize())
-		expectedSummariesDigest := calculateExpectedChecksum(t, summariesFilePath)
+		expectedSummariesDigest := calculateExpectedChecksum(t, memFs, summariesFilePath)
 		decoder := msgpack.NewDecoder(options.DecodingOptions())
-		indexLookup, err := readIndexLookupFromSummariesFile(
+		plainLookup, err := readIndexLookupFromSummariesFile(
 			summariesFile, summariesFdWithDigest, expectedSummariesDigest, decoder, len(writes))
 		if err != nil {
 			return false, fmt.Errorf("err reading index lookup from summaries file: %v, ", err)
 		}
 
-		// Make sure it returns the correct index offset for every ID
+		// Read the Bloom filter sidecar and wrap the lookup with it, so
+		// that every assertion below exercises the filter short-circuit
+		// as well as the radixTree.
+		bloomFilePath := filesetPathFromTime(
+			shardDirPath, testWriterStart, bloomFilterFileSuffix)
+		bloomFile, err := memFs.Open(bloomFilePath)
+		if err != nil {
+			return false, fmt.Errorf("err opening bloom filter file: %v, ", err)
+		}
+		bloomFdWithDigest := digest.NewFdWithDigestReader(options.InfoReaderBufferSize())
+		expectedBloomDigest := calculateExpectedChecksum(t, memFs, bloomFilePath)
+		indexLookup, err := newBloomFilteredIndexLookup(
+			plainLookup, bloomFile, bloomFdWithDigest, expectedBloomDigest)
+		if err != nil {
+			return false, fmt.Errorf("err reading bloom filtered index lookup: %v, ", err)
+		}
+
+		// The Bloom filter must never produce a false negative for an ID
+		// that was actually written.
+		for id := range expectedIndexFileOffsets {
+			if !indexLookup.filter.Test([]byte(id)) {
+				return false, fmt.Errorf("bloom filter false negative for: %s", id)
+			}
+		}
+
+		// Make sure it returns a usable index offset for every ID. With
+		// indexSummariesPercent < 1, an ID need not have its own summaries
+		// entry, so getNearestIndexFileOffset is only promised the nearest
+		// preceding sampled entry, not an exact match; assert the weaker
+		// "at or before" bound that's actually guaranteed, and the exact
+		// bound falls out of it automatically when indexSummariesPercent
+		// happens to land at 1.
 		for id, expectedOffset := range expectedIndexFileOffsets {
 			foundOffset, ok, err := indexLookup.getNearestIndexFileOffset(ts.StringID(id))
 			if err != nil {
@@ -121,9 +206,9 @@ func TestIndexLookupWriteRead(t *testing.T) {
 			if !ok {
 				return false, fmt.Errorf("Unable to locate index file offset for: %s", id)
 			}
-			if expectedOffset != foundOffset {
+			if foundOffset > expectedOffset {
 				return false, fmt.Errorf(
-					"Offsets for: %s do not match, expected: %d, got: %d",
+					"Offset for: %s must be at or before its own index entry, expected at most: %d, got: %d",
 					id, expectedOffset, foundOffset)
 			}
 		}
@@ -138,16 +223,16 @@ func TestIndexLookupWriteRead(t *testing.T) {
 			fakeWrites = append(fakeWrites, fakeWrite)
 		}
 
-		// // Make sure it returns false for IDs that do not exist
-		// for _, fakeWrite := range fakeWrites {
-		// 	_, ok, err := indexLookup.getNearestIndexFileOffset(fakeWrite.id)
-		// 	if err != nil {
-		// 		return false, fmt.Errorf("Err locating index file offset for: %s, err: %v", fakeWrite.id, err)
-		// 	}
-		// 	if ok {
-		// 		return false, fmt.Errorf("Found locate index file offset for: %s which should not have been found", fakeWrite.id)
-		// 	}
-		// }
+		// Make sure it returns false for IDs that do not exist
+		for _, fakeWrite := range fakeWrites {
+			_, ok, err := indexLookup.getNearestIndexFileOffset(fakeWrite.id)
+			if err != nil {
+				return false, fmt.Errorf("Err locating index file offset for: %s, err: %v", fakeWrite.id, err)
+			}
+			if ok {
+				return false, fmt.Errorf("Found locate index file offset for: %s which should not have been found", fakeWrite.id)
+			}
+		}
 
 		return true, nil
 	}
@@ -165,8 +250,132 @@ func TestIndexLookupWriteRead(t *testing.T) {
 	props.TestingRun(t)
 }
 
-func calculateExpectedChecksum(t *testing.T, filePath string) uint32 {
-	fileBytes, err := ioutil.ReadFile(filePath)
+// TestIndexLookupNearestLowerKeyForSparseSummaries pins the sparse case
+// TestIndexLookupWriteRead now samples randomly (the default is 3%, here
+// 20% to keep the fixture small) down to a single deterministic fixture:
+// with most written IDs having no exact entry in the radixTree,
+// getNearestIndexFileOffset must fall back to the nearest preceding
+// sampled entry instead of reporting ok=false.
+func TestIndexLookupNearestLowerKeyForSparseSummaries(t *testing.T) {
+	const numWrites = 50
+
+	memFs := NewMemFilesystem()
+	filePathPrefix := "testdb"
+
+	options := NewOptions().
+		SetIndexSummariesPercent(0.2).
+		SetFilePathPrefix(filePathPrefix).
+		SetWriterBufferSize(testWriterBufferSize).
+		SetFilesystem(memFs)
+
+	var shard uint32 = 7
+
+	// IDs must be written in sorted order (writer.Write's documented
+	// contract), which is what makes "nearest lower summarized entry" a
+	// meaningful answer in the first place.
+	writes := make([]generatedWrite, 0, numWrites)
+	for i := 0; i < numWrites; i++ {
+		payload := testPayloads[i%len(testPayloads)]
+		writes = append(writes, generatedWrite{
+			id:       ts.StringID(fmt.Sprintf("id-%04d", i)),
+			data:     bytesRefd(payload),
+			checksum: digest.Checksum(payload),
+		})
+	}
+
+	w, err := NewWriter(options)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Open(testNs1ID, testBlockSize, shard, testWriterStart))
+	assert.NoError(t, writeTestSummariesData(w, writes))
+
+	shardDirPath := ShardDirPath(filePathPrefix, testNs1ID, shard)
+	expectedEntries, err := readIndexFileOffsets(memFs, shardDirPath, len(writes), testWriterStart)
+	assert.NoError(t, err)
+
+	summariesFilePath := filesetPathFromTime(shardDirPath, testWriterStart, summariesFileSuffix)
+	summariesFile, err := memFs.Open(summariesFilePath)
+	assert.NoError(t, err)
+	summariesFdWithDigest := digest.NewFdWithDigestReader(options.InfoReaderBufferSize())
+	expectedSummariesDigest := calculateExpectedChecksum(t, memFs, summariesFilePath)
+	decoder := msgpack.NewDecoder(options.DecodingOptions())
+	lookup, err := readIndexLookupFromSummariesFile(
+		summariesFile, summariesFdWithDigest, expectedSummariesDigest, decoder, len(writes))
+	assert.NoError(t, err)
+
+	for _, write := range writes {
+		id := string(write.id.Data().Get())
+		offset, ok, err := lookup.getNearestIndexFileOffset(write.id)
+		assert.NoError(t, err)
+		assert.True(t, ok, "expected ok=true for written id %s even though only a sparse sample was summarized", id)
+		assert.True(t, offset <= expectedEntries[id].indexFileOffset,
+			"nearest lower offset for %s must be at or before its own index entry", id)
+	}
+}
+
+// TestWriterSummariesFileContainsOnlyNewSummaries guards against
+// (*writer).Write re-writing its whole encoder buffer on every summary
+// instead of just the newly-encoded bytes: with indexSummariesPercent(1)
+// every real write appends exactly one IndexSummary, so the summaries
+// file must decode to exactly len(writes) entries, no more.
+func TestWriterSummariesFileContainsOnlyNewSummaries(t *testing.T) {
+	const numWrites = 10
+
+	memFs := NewMemFilesystem()
+	filePathPrefix := "testdb"
+	var shard uint32 = 1
+
+	options := NewOptions().
+		SetIndexSummariesPercent(1).
+		SetFilePathPrefix(filePathPrefix).
+		SetWriterBufferSize(testWriterBufferSize).
+		SetFilesystem(memFs)
+
+	writes := make([]generatedWrite, 0, numWrites)
+	for i := 0; i < numWrites; i++ {
+		payload := testPayloads[i%len(testPayloads)]
+		writes = append(writes, generatedWrite{
+			id:       ts.StringID(fmt.Sprintf("id-%04d", i)),
+			data:     bytesRefd(payload),
+			checksum: digest.Checksum(payload),
+		})
+	}
+
+	w, err := NewWriter(options)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Open(testNs1ID, testBlockSize, shard, testWriterStart))
+	assert.NoError(t, writeTestSummariesData(w, writes))
+
+	shardDirPath := ShardDirPath(filePathPrefix, testNs1ID, shard)
+	summariesFilePath := filesetPathFromTime(shardDirPath, testWriterStart, summariesFileSuffix)
+	summariesFile, err := memFs.Open(summariesFilePath)
+	assert.NoError(t, err)
+
+	summariesFdWithDigest := digest.NewFdWithDigestReader(options.InfoReaderBufferSize())
+	expectedSummariesDigest := calculateExpectedChecksum(t, memFs, summariesFilePath)
+	decoder := msgpack.NewDecoder(options.DecodingOptions())
+	decodedCount := 0
+	fdWithDigest := summariesFdWithDigest
+	fdWithDigest.Reset(summariesFile)
+	buf, err := fdWithDigest.ReadAllAndValidate(expectedSummariesDigest)
+	assert.NoError(t, err)
+	decoder.Reset(encoding.NewDecoderStream(buf))
+	for {
+		_, err := decoder.DecodeIndexSummary()
+		if err != nil {
+			break
+		}
+		decodedCount++
+	}
+
+	assert.Equal(t, numWrites, decodedCount,
+		"summaries file should contain exactly one entry per write, not the writer's whole "+
+			"encoder history re-appended on every Write")
+}
+
+func calculateExpectedChecksum(t *testing.T, fs Filesystem, filePath string) uint32 {
+	f, err := fs.Open(filePath)
+	assert.NoError(t, err)
+	fileBytes, err := ioutil.ReadAll(f)
 	assert.NoError(t, err)
 	return digest.Checksum(fileBytes)
 }
@@ -188,6 +397,10 @@ type propTestInput struct {
 	fakeWrites []generatedWrite
 	// Shard number to use for the files
 	shard uint32
+	// Fraction of index entries also written to the summaries file; varied
+	// (rather than pinned at 1) so the property test actually exercises
+	// getNearestIndexFileOffset's nearest-lower-key fallback.
+	indexSummariesPercent float64
 }
 
 type generatedWrite struct {
@@ -213,25 +426,45 @@ func genPropTestInput(numRealWrites, numFakeWrites int) gopter.Gen {
 		gen.SliceOfN(numRealWrites, genWrite()),
 		gen.SliceOfN(numFakeWrites, genWrite()),
 		gen.UInt32(),
+		gen.Float64Range(0.05, 1),
 	).Map(func(vals []interface{}) propTestInput {
 		return propTestInput{
-			realWrites: vals[0].([]generatedWrite),
-			fakeWrites: vals[1].([]generatedWrite),
-			shard:      vals[2].(uint32),
+			realWrites:            vals[0].([]generatedWrite),
+			fakeWrites:            vals[1].([]generatedWrite),
+			shard:                 vals[2].(uint32),
+			indexSummariesPercent: vals[3].(float64),
 		}
 	})
 }
 
+// testPayloads is a small, fixed pool of distinct 100-byte payloads that
+// genWrite samples from instead of generating fully random bytes for
+// every write. Drawing from a pool this small all but guarantees that
+// any propTestInput with more than a handful of real writes contains
+// byte-identical blocks, which is what exercises the content-addressable
+// dedup path in TestIndexLookupWriteRead.
+var testPayloads = func() [][]byte {
+	payloads := make([][]byte, 5)
+	for i := range payloads {
+		payload := make([]byte, 100)
+		for j := range payload {
+			payload[j] = byte(i)
+		}
+		payloads[i] = payload
+	}
+	return payloads
+}()
+
 func genWrite() gopter.Gen {
 	return gopter.CombineGens(
 		// gopter will generate random strings, but some of them may be duplicates
 		// (which can't normally happen for IDs and breaks this codepath), so we
 		// filter down to unique inputs
 		gen.AnyString(),
-		gen.SliceOfN(100, gen.UInt8()),
+		gen.IntRange(0, len(testPayloads)-1),
 	).Map(func(vals []interface{}) generatedWrite {
 		id := vals[0].(string)
-		data := vals[1].([]byte)
+		data := testPayloads[vals[1].(int)]
 
 		return generatedWrite{
 			id:       ts.StringID(id),
@@ -241,9 +474,25 @@ func genWrite() gopter.Gen {
 	})
 }
 
-func readIndexFileOffsets(shardDirPath string, numEntries int, start time.Time) (map[string]int64, error) {
+// indexFileEntry captures everything readIndexFileOffsets pulls out of an
+// index file entry: indexFileOffset is compared against
+// fileSetIndexLookup.getNearestIndexFileOffset, while dataOffset/size/
+// checksum let callers verify the referenced data file bytes directly
+// (including when they're shared with another ID via dedup).
+type indexFileEntry struct {
+	indexFileOffset int64
+	dataOffset      int64
+	size            int64
+	checksum        uint32
+}
+
+func readIndexFileOffsets(fs Filesystem, shardDirPath string, numEntries int, start time.Time) (map[string]indexFileEntry, error) {
 	indexFilePath := filesetPathFromTime(shardDirPath, start, indexFileSuffix)
-	buf, err := ioutil.ReadFile(indexFilePath)
+	indexFile, err := fs.Open(indexFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("err opening index file: %v, ", err)
+	}
+	buf, err := ioutil.ReadAll(indexFile)
 	if err != nil {
 		return nil, fmt.Errorf("err reading index file: %v, ", err)
 	}
@@ -252,14 +501,19 @@ func readIndexFileOffsets(shardDirPath string, numEntries int, start time.Time)
 	decoder := msgpack.NewDecoder(NewOptions().DecodingOptions())
 	decoder.Reset(decoderStream)
 
-	summariesOffsets := map[string]int64{}
+	entries := map[string]indexFileEntry{}
 	for read := 0; read < numEntries; read++ {
 		offset := int64(len(buf)) - (decoderStream.Remaining())
 		entry, err := decoder.DecodeIndexEntry()
 		if err != nil {
 			return nil, fmt.Errorf("err decoding index entry: %v", err)
 		}
-		summariesOffsets[string(entry.ID)] = offset
+		entries[string(entry.ID)] = indexFileEntry{
+			indexFileOffset: offset,
+			dataOffset:      entry.Offset,
+			size:            entry.Size,
+			checksum:        uint32(entry.Checksum),
+		}
 	}
-	return summariesOffsets, nil
+	return entries, nil
 }
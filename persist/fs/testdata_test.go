@@ -0,0 +1,44 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"time"
+
+	"github.com/m3db/m3db/ts"
+
+	"github.com/m3db/m3x/checked"
+)
+
+var (
+	testNs1ID            = ts.StringID("testNs1")
+	testBlockSize        = 2 * time.Hour
+	testWriterStart      = time.Unix(1536504365, 0)
+	testWriterBufferSize = 10
+)
+
+// bytesRefd wraps data in a checked.Bytes with no finalizer, for use in
+// tests that do not exercise the ref-counted pooling path.
+func bytesRefd(data []byte) checked.Bytes {
+	b := checked.NewBytes(data, nil)
+	b.IncRef()
+	return b
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/m3db/m3db/digest"
+	"github.com/m3db/m3db/persist/encoding/msgpack"
+	"github.com/m3db/m3db/persist/schema"
+	"github.com/m3db/m3db/ts"
+
+	"github.com/m3db/m3x/checked"
+)
+
+// FileSetWriter writes out the data, index, summaries, info and
+// checkpoint files that make up a fileset for a single shard/block.
+type FileSetWriter interface {
+	// Open opens the files for the given namespace/shard/block for
+	// writing, creating the shard directory if it does not yet exist.
+	Open(namespace ts.ID, blockSize time.Duration, shard uint32, start time.Time) error
+
+	// Write writes the given id's encoded data and its checksum. IDs
+	// must be written in sorted order. When Options.ContentAddressable()
+	// is enabled, a block whose bytes are identical to one already
+	// written to this shard/block is deduped: only an index entry
+	// pointing at the existing data file offset is written.
+	Write(id ts.ID, data checked.Bytes, checksum uint32) error
+
+	// Close flushes and closes all the files opened by Open.
+	Close() error
+}
+
+type writer struct {
+	options Options
+	fs      Filesystem
+
+	shardDir  string
+	start     time.Time
+	blockSize time.Duration
+
+	indexFd      File
+	summariesFd  File
+	dataFd       File
+	bloomFd      File
+	infoFd       File
+	checkpointFd File
+
+	dataDigest      hash.Hash32
+	indexDigest     hash.Hash32
+	summariesDigest hash.Hash32
+	bloomDigest     hash.Hash32
+
+	indexEncoder     *msgpack.Encoder
+	summariesEncoder *msgpack.Encoder
+
+	currIdx           int64
+	currOffset        int64
+	indexEntriesSoFar int
+	summarizeEvery    int
+
+	writtenIDs   [][]byte
+	contentIndex map[[sha256.Size]byte]int64
+}
+
+// NewWriter returns a new FileSetWriter backed by the Filesystem
+// configured on opts (an OS-backed Filesystem by default).
+func NewWriter(opts Options) (FileSetWriter, error) {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	return &writer{
+		options:          opts,
+		fs:               opts.Filesystem(),
+		indexEncoder:     msgpack.NewEncoder(),
+		summariesEncoder: msgpack.NewEncoder(),
+	}, nil
+}
+
+func (w *writer) Open(namespace ts.ID, blockSize time.Duration, shard uint32, start time.Time) error {
+	shardDir := ShardDirPath(w.options.FilePathPrefix(), namespace, shard)
+	if err := mkdirIfNotExist(w.fs, shardDir); err != nil {
+		return fmt.Errorf("err creating shard dir: %v", err)
+	}
+
+	var err error
+	if w.dataFd, err = w.fs.Create(filesetPathFromTime(shardDir, start, dataFileSuffix)); err != nil {
+		return err
+	}
+	if w.indexFd, err = w.fs.Create(filesetPathFromTime(shardDir, start, indexFileSuffix)); err != nil {
+		return err
+	}
+	if w.summariesFd, err = w.fs.Create(filesetPathFromTime(shardDir, start, summariesFileSuffix)); err != nil {
+		return err
+	}
+	if w.bloomFd, err = w.fs.Create(filesetPathFromTime(shardDir, start, bloomFilterFileSuffix)); err != nil {
+		return err
+	}
+	if w.infoFd, err = w.fs.Create(filesetPathFromTime(shardDir, start, infoFileSuffix)); err != nil {
+		return err
+	}
+	if w.checkpointFd, err = w.fs.Create(filesetPathFromTime(shardDir, start, checkpointFileSuffix)); err != nil {
+		return err
+	}
+
+	w.shardDir = shardDir
+	w.start = start
+	w.blockSize = blockSize
+	w.currIdx = 0
+	w.currOffset = 0
+	w.indexEntriesSoFar = 0
+	w.writtenIDs = w.writtenIDs[:0]
+	w.contentIndex = nil
+	if w.options.ContentAddressable() {
+		w.contentIndex = make(map[[sha256.Size]byte]int64)
+	}
+
+	w.dataDigest = digest.NewDigest()
+	w.indexDigest = digest.NewDigest()
+	w.summariesDigest = digest.NewDigest()
+	w.bloomDigest = digest.NewDigest()
+
+	// Determine how frequently (in number of index entries) we should
+	// also emit a summaries entry, so that roughly
+	// options.IndexSummariesPercent() of entries end up in the summaries
+	// file.
+	percent := w.options.IndexSummariesPercent()
+	if percent <= 0 {
+		w.summarizeEvery = 0
+	} else if percent >= 1 {
+		w.summarizeEvery = 1
+	} else {
+		w.summarizeEvery = int(1 / percent)
+	}
+
+	return nil
+}
+
+func (w *writer) Write(id ts.ID, data checked.Bytes, checksum uint32) error {
+	idBytes := id.Data().Get()
+	dataBytes := data.Get()
+
+	dataOffset, deduped := w.offsetForData(dataBytes)
+	if !deduped {
+		if _, err := io.MultiWriter(w.dataFd, w.dataDigest).Write(dataBytes); err != nil {
+			return err
+		}
+		w.currOffset += int64(len(dataBytes))
+	}
+
+	entry := schema.IndexEntry{
+		Index:    w.currIdx,
+		ID:       idBytes,
+		Size:     int64(len(dataBytes)),
+		Offset:   dataOffset,
+		Checksum: int64(checksum),
+	}
+
+	indexOffsetBefore := w.indexEncoder.Len()
+	if err := w.indexEncoder.EncodeIndexEntry(entry); err != nil {
+		return err
+	}
+	encoded := w.indexEncoder.Bytes()
+	if _, err := io.MultiWriter(w.indexFd, w.indexDigest).Write(encoded[indexOffsetBefore:]); err != nil {
+		return err
+	}
+
+	if w.summarizeEvery > 0 && w.indexEntriesSoFar%w.summarizeEvery == 0 {
+		summary := schema.IndexSummary{
+			Index:            entry.Index,
+			ID:               entry.ID,
+			IndexEntryOffset: indexOffsetBefore,
+		}
+		summaryOffsetBefore := w.summariesEncoder.Len()
+		if err := w.summariesEncoder.EncodeIndexSummary(summary); err != nil {
+			return err
+		}
+		summaryEncoded := w.summariesEncoder.Bytes()
+		if _, err := io.MultiWriter(w.summariesFd, w.summariesDigest).Write(summaryEncoded[summaryOffsetBefore:]); err != nil {
+			return err
+		}
+	}
+
+	w.writtenIDs = append(w.writtenIDs, idBytes)
+
+	w.currIdx++
+	w.indexEntriesSoFar++
+	return nil
+}
+
+// offsetForData returns the data file offset at which dataBytes resides,
+// and whether it was already there. When content-addressable dedup is
+// disabled (the common case) every write lands at the current end of the
+// data file. When it's enabled, dataBytes is hashed with SHA-256 (the
+// 32-bit checksum passed to Write is too collision-prone to key a store
+// off of) and checked against every block written so far in this
+// shard/block; a hit means the caller can skip writing dataBytes again.
+func (w *writer) offsetForData(dataBytes []byte) (offset int64, deduped bool) {
+	if w.contentIndex == nil {
+		return w.currOffset, false
+	}
+
+	key := sha256.Sum256(dataBytes)
+	if existing, ok := w.contentIndex[key]; ok {
+		return existing, true
+	}
+
+	w.contentIndex[key] = w.currOffset
+	return w.currOffset, false
+}
+
+func (w *writer) Close() error {
+	filter := newBloomFilter(len(w.writtenIDs), w.options.IndexBloomFilterFalsePositivePercent())
+	for _, id := range w.writtenIDs {
+		filter.Add(id)
+	}
+	filterBytes := filter.bytes()
+	if _, err := io.MultiWriter(w.bloomFd, w.bloomDigest).Write(filterBytes); err != nil {
+		return err
+	}
+
+	for _, fd := range []File{w.dataFd, w.indexFd, w.summariesFd, w.bloomFd} {
+		if fd == nil {
+			continue
+		}
+		if err := fd.Sync(); err != nil {
+			return err
+		}
+		if err := fd.Close(); err != nil {
+			return err
+		}
+	}
+
+	if w.infoFd != nil {
+		info := infoFileDigests{
+			dataDigest:      w.dataDigest.Sum32(),
+			indexDigest:     w.indexDigest.Sum32(),
+			summariesDigest: w.summariesDigest.Sum32(),
+			bloomDigest:     w.bloomDigest.Sum32(),
+		}
+		if _, err := w.infoFd.Write(info.bytes()); err != nil {
+			return err
+		}
+		if err := w.infoFd.Sync(); err != nil {
+			return err
+		}
+		if err := w.infoFd.Close(); err != nil {
+			return err
+		}
+	}
+
+	if w.checkpointFd != nil {
+		if _, err := w.checkpointFd.Write([]byte{1}); err != nil {
+			return err
+		}
+		if err := w.checkpointFd.Sync(); err != nil {
+			return err
+		}
+		if err := w.checkpointFd.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}